@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminAuthMiddleware 用 HTTP Basic Auth 保护 /admin 下所有路由，
+// 用户名用 subtle.ConstantTimeCompare、密码用 bcrypt 校验，
+// 避免 /error_videos 和 /api/errors 那种裸奔访问。
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		if !ok || !validAdminCredentials(username, password) {
+			c.Header("WWW-Authenticate", `Basic realm="bgiSRecording admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "需要管理员登录"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func validAdminCredentials(username, password string) bool {
+	if config.Admin.Username == "" || config.Admin.PasswordHash == "" {
+		return false
+	}
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(config.Admin.Username)) == 1
+	passwordOK := bcrypt.CompareHashAndPassword([]byte(config.Admin.PasswordHash), []byte(password)) == nil
+	return usernameOK && passwordOK
+}
+
+// clipTags 是随片段一起持久化的标签/备注，保存在 <clip>.tags.json 里。
+type clipTags struct {
+	Tags []string `json:"tags"`
+	Note string   `json:"note"`
+}
+
+func tagsPath(clipName string) string {
+	return filepath.Join(errorVideoDir, clipName+".tags.json")
+}
+
+// readClipTags 读回某个片段已保存的标签/备注，供 /api/errors 把标签数据
+// 合并进列表里，否则前端每次刷新都看不到之前打的标签。
+func readClipTags(clipName string) (clipTags, bool) {
+	data, err := os.ReadFile(tagsPath(clipName))
+	if err != nil {
+		return clipTags{}, false
+	}
+	var tags clipTags
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return clipTags{}, false
+	}
+	return tags, true
+}
+
+// registerAdminRoutes 把后台用到的路由都挂在同一个 gin.RouterGroup 下，
+// 这样鉴权中间件只需要配置一次。
+func registerAdminRoutes(admin *gin.RouterGroup, webFS fs.FS) {
+	admin.GET("", func(c *gin.Context) {
+		data, err := webFS.Open("admin.html")
+		if err != nil {
+			c.String(http.StatusNotFound, "admin.html not found")
+			return
+		}
+		c.DataFromReader(http.StatusOK, -1, "text/html; charset=utf-8", data, nil)
+	})
+
+	admin.POST("/login", func(c *gin.Context) {
+		// 中间件已经校验过凭据，走到这里就说明登录成功。
+		c.JSON(http.StatusOK, gin.H{"ok": true, "username": config.Admin.Username})
+	})
+
+	admin.DELETE("/video/:name", func(c *gin.Context) {
+		name := filepath.Base(c.Param("name"))
+		full := filepath.Join(errorVideoDir, name)
+		if _, err := os.Stat(full); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		if err := removeClipFiles(full); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := deleteIncidentByClipPath(full); err != nil {
+			log.Printf("同步删除事件记录失败: %v", err)
+		}
+		logAdminMutation(c, fmt.Sprintf("删除片段 %s", name))
+		c.JSON(http.StatusOK, gin.H{"deleted": name})
+	})
+
+	admin.GET("/video/:name/download", func(c *gin.Context) {
+		name := filepath.Base(c.Param("name"))
+		full := filepath.Join(errorVideoDir, name)
+		if _, err := os.Stat(full); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		c.File(full)
+	})
+
+	admin.POST("/video/:name/tag", func(c *gin.Context) {
+		name := filepath.Base(c.Param("name"))
+		full := filepath.Join(errorVideoDir, name)
+		if _, err := os.Stat(full); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		var tags clipTags
+		if err := c.ShouldBindJSON(&tags); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := json.Marshal(tags)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := os.WriteFile(tagsPath(name), data, 0644); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logAdminMutation(c, fmt.Sprintf("更新片段 %s 的标签: %v", name, tags.Tags))
+		c.JSON(http.StatusOK, tags)
+	})
+}
+
+func logAdminMutation(c *gin.Context, action string) {
+	log.Printf("🔒 [admin %s] %s (%s)", c.ClientIP(), action, time.Now().Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentInfo 描述一个录制分段：起始时间来自 strftime 文件名，
+// 时长在分段关闭后通过下一段的起始时间（或 ffprobe 兜底）推算。
+type segmentInfo struct {
+	Path      string
+	StartTime time.Time
+	Duration  time.Duration
+}
+
+// scanSegments 读取 videoDir 下现存的 .ts 分段，按起始时间升序排列，
+// 充当 extractClip 用来挑选片段的“环形缓冲区”快照。
+func scanSegments() ([]segmentInfo, error) {
+	files, err := filepath.Glob(filepath.Join(videoDir, segmentFilePrefix+"*"+segmentFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("获取分段列表失败: %v", err)
+	}
+
+	var segments []segmentInfo
+	for _, f := range files {
+		base := strings.TrimSuffix(filepath.Base(f), segmentFileExt)
+		base = strings.TrimPrefix(base, segmentFilePrefix)
+		start, err := time.ParseInLocation(segmentTimeLayout, base, time.Local)
+		if err != nil {
+			log.Printf("跳过无法解析时间的分段: %s (%v)", f, err)
+			continue
+		}
+		segments = append(segments, segmentInfo{Path: f, StartTime: start})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].StartTime.Before(segments[j].StartTime)
+	})
+
+	for i := range segments {
+		if i < len(segments)-1 {
+			segments[i].Duration = segments[i+1].StartTime.Sub(segments[i].StartTime)
+			continue
+		}
+		// 最后一段可能仍在写入，先用 ffprobe 测一次实际时长。
+		if d, err := probeDuration(segments[i].Path); err == nil {
+			segments[i].Duration = d
+		} else {
+			segments[i].Duration = time.Duration(segmentTime) * time.Second
+		}
+	}
+
+	return segments, nil
+}
+
+// probeDuration 用 ffprobe 读取分段文件的实际时长，
+// 用于补全文件名推算不到的最后一段时长。
+func probeDuration(path string) (time.Duration, error) {
+	cmd := exec.Command(ffprobeExePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe 执行失败: %v", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe 输出解析失败: %v", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// extractClip 在关键词触发后，截取 [triggerTime-preRoll, triggerTime+postRoll]
+// 这段时间窗口，无损 remux 成一个 MP4 文件，而不是整段复制原始分段。
+func extractClip(triggerTime time.Time, keyword string, incidentID int64) error {
+	copyLock.Lock()
+	defer copyLock.Unlock()
+
+	if err := os.MkdirAll(errorVideoDir, os.ModePerm); err != nil {
+		return fmt.Errorf("创建错误视频目录失败: %v", err)
+	}
+
+	preRoll := time.Duration(config.ClipPreRollSeconds) * time.Second
+	postRoll := time.Duration(config.ClipPostRollSeconds) * time.Second
+	windowStart := triggerTime.Add(-preRoll)
+	windowEnd := triggerTime.Add(postRoll)
+
+	segments, err := waitForWindowClosed(windowEnd)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("没有可用的录制分段")
+	}
+
+	// 预卷窗口早于当前保留的最老分段时，夹紧到最老分段的起点。
+	if windowStart.Before(segments[0].StartTime) {
+		log.Printf("预卷窗口早于最老保留分段，已夹紧起点: %s", segments[0].StartTime.Format(segmentTimeLayout))
+		windowStart = segments[0].StartTime
+	}
+
+	var overlapping []segmentInfo
+	for _, s := range segments {
+		segEnd := s.StartTime.Add(s.Duration)
+		if segEnd.After(windowStart) && s.StartTime.Before(windowEnd) {
+			overlapping = append(overlapping, s)
+		}
+	}
+	if len(overlapping) == 0 {
+		return fmt.Errorf("没有分段覆盖触发时间窗口 [%v, %v]", windowStart, windowEnd)
+	}
+
+	listFile, err := writeConcatList(overlapping)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(listFile)
+
+	indexData, err := fetchIndexData()
+	if err != nil {
+		log.Printf("无法获取索引信息: %v", err)
+		indexData = map[string]interface{}{
+			"scriptName": "unknown",
+			"line":       "unknown",
+		}
+	}
+
+	offset := windowStart.Sub(overlapping[0].StartTime)
+	if offset < 0 {
+		offset = 0
+	}
+	duration := windowEnd.Sub(windowStart)
+
+	errFileName := fmt.Sprintf("%s_%s_%s.mp4",
+		fmt.Sprint(indexData["scriptName"]),
+		filepath.Base(fmt.Sprint(indexData["line"])),
+		triggerTime.Format("20060102150405"),
+	)
+	dest := filepath.Join(errorVideoDir, errFileName)
+
+	cmd := exec.Command(ffmpegExePath,
+		"-y",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile,
+		"-ss", formatSeconds(offset),
+		"-t", formatSeconds(duration),
+		"-c", "copy",
+		dest,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("截取片段失败: %v", err)
+	}
+
+	log.Printf("✅ 关键词 [%s] 触发，已生成片段: %s", keyword, dest)
+
+	if incidentID != 0 {
+		size := int64(0)
+		if fi, err := os.Stat(dest); err == nil {
+			size = fi.Size()
+		}
+		if err := markIncidentReady(incidentID, fmt.Sprint(indexData["scriptName"]), fmt.Sprint(indexData["line"]), dest, size, duration.Seconds()); err != nil {
+			log.Printf("更新事件记录失败: %v", err)
+		}
+	}
+
+	if config.Upload.Remote != "" {
+		enqueueUpload(dest)
+	}
+	return nil
+}
+
+// waitForWindowClosed 确保触发窗口末尾所在的分段已经写完，
+// 否则会拿到一段还在增长、内容不完整的 .ts 文件。
+func waitForWindowClosed(windowEnd time.Time) ([]segmentInfo, error) {
+	for i := 0; i < 6; i++ {
+		segments, err := scanSegments()
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		last := segments[len(segments)-1]
+		if !windowEnd.After(last.StartTime) || waitForFileStable(last.Path, 30*time.Second) {
+			return segments, nil
+		}
+		log.Printf("触发窗口末尾落在仍在写入的分段内，等待其关闭: %s", last.Path)
+	}
+	return scanSegments()
+}
+
+// writeConcatList 生成 ffmpeg concat demuxer 需要的分段列表文件。
+func writeConcatList(segments []segmentInfo) (string, error) {
+	f, err := os.CreateTemp("", "concat_*.txt")
+	if err != nil {
+		return "", fmt.Errorf("创建分段列表失败: %v", err)
+	}
+	defer f.Close()
+
+	for _, s := range segments {
+		abs, err := filepath.Abs(s.Path)
+		if err != nil {
+			abs = s.Path
+		}
+		if _, err := fmt.Fprintf(f, "file '%s'\n", abs); err != nil {
+			return "", fmt.Errorf("写入分段列表失败: %v", err)
+		}
+	}
+	return f.Name(), nil
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const incidentDBPath = "./incidents.db"
+
+// Incident 对应 incidents 表的一行，即一次关键词触发产生的事件记录。
+type Incident struct {
+	ID           int64     `json:"id"`
+	TriggerTime  time.Time `json:"triggerTime"`
+	Keyword      string    `json:"keyword"`
+	LogLine      string    `json:"logLine"`
+	ScriptName   string    `json:"scriptName"`
+	Line         string    `json:"line"`
+	ClipPath     string    `json:"clipPath"`
+	ClipSize     int64     `json:"clipSize"`
+	ClipDuration float64   `json:"clipDuration"`
+	Status       string    `json:"status"` // pending / ready / failed
+}
+
+var incidentDB *sql.DB
+
+var dbInfoFlag = flag.Bool("dbinfo", false, "只读打开事件数据库并打印统计信息后退出")
+
+const createIncidentsTableSQL = `
+CREATE TABLE IF NOT EXISTS incidents (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	trigger_time  DATETIME NOT NULL,
+	keyword       TEXT NOT NULL,
+	log_line      TEXT,
+	script_name   TEXT,
+	line          TEXT,
+	clip_path     TEXT,
+	clip_size     INTEGER NOT NULL DEFAULT 0,
+	clip_duration REAL NOT NULL DEFAULT 0,
+	status        TEXT NOT NULL DEFAULT 'pending'
+);
+`
+
+// initIncidentDB 打开（或新建）事件数据库，建表，并在首次运行时
+// 把 error_videos 目录下按旧文件名约定保存的文件迁移进数据库。
+func initIncidentDB() error {
+	db, err := sql.Open("sqlite", incidentDBPath)
+	if err != nil {
+		return fmt.Errorf("打开事件数据库失败: %v", err)
+	}
+	if _, err := db.Exec(createIncidentsTableSQL); err != nil {
+		return fmt.Errorf("初始化事件表失败: %v", err)
+	}
+	incidentDB = db
+
+	if err := migrateExistingErrorVideos(); err != nil {
+		log.Printf("迁移历史错误视频失败: %v", err)
+	}
+	return nil
+}
+
+// insertIncident 在关键词刚触发、片段还没截取完成时先插入一行 pending 记录。
+func insertIncident(triggerTime time.Time, keyword, logLine string) (int64, error) {
+	res, err := incidentDB.Exec(
+		`INSERT INTO incidents (trigger_time, keyword, log_line, status) VALUES (?, ?, ?, 'pending')`,
+		triggerTime, keyword, logLine,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("写入事件记录失败: %v", err)
+	}
+	return res.LastInsertId()
+}
+
+// markIncidentReady 在片段截取成功后回填脚本信息、文件信息并置为 ready。
+func markIncidentReady(id int64, scriptName, line, clipPath string, size int64, duration float64) error {
+	_, err := incidentDB.Exec(
+		`UPDATE incidents SET script_name = ?, line = ?, clip_path = ?, clip_size = ?, clip_duration = ?, status = 'ready' WHERE id = ?`,
+		scriptName, line, clipPath, size, duration, id,
+	)
+	if err != nil {
+		return fmt.Errorf("更新事件记录失败: %v", err)
+	}
+	return nil
+}
+
+// markIncidentFailed 在片段截取失败时把记录标记为 failed，而不是悄悄丢弃。
+func markIncidentFailed(id int64) error {
+	_, err := incidentDB.Exec(`UPDATE incidents SET status = 'failed' WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("更新事件记录失败: %v", err)
+	}
+	return nil
+}
+
+// incidentFilter 对应 /api/incidents 支持的查询参数。
+type incidentFilter struct {
+	Keyword string
+	From    string
+	To      string
+	Script  string
+	Limit   int
+	Offset  int
+}
+
+func listIncidents(f incidentFilter) ([]Incident, error) {
+	query := `SELECT id, trigger_time, keyword, log_line, script_name, line, clip_path, clip_size, clip_duration, status FROM incidents WHERE 1 = 1`
+	var args []interface{}
+
+	if f.Keyword != "" {
+		query += " AND keyword = ?"
+		args = append(args, f.Keyword)
+	}
+	if f.Script != "" {
+		query += " AND script_name = ?"
+		args = append(args, f.Script)
+	}
+	if f.From != "" {
+		query += " AND trigger_time >= ?"
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		query += " AND trigger_time <= ?"
+		args = append(args, f.To)
+	}
+	query += " ORDER BY trigger_time DESC LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := incidentDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询事件列表失败: %v", err)
+	}
+	defer rows.Close()
+
+	var result []Incident
+	for rows.Next() {
+		var inc Incident
+		if err := rows.Scan(&inc.ID, &inc.TriggerTime, &inc.Keyword, &inc.LogLine, &inc.ScriptName, &inc.Line, &inc.ClipPath, &inc.ClipSize, &inc.ClipDuration, &inc.Status); err != nil {
+			return nil, fmt.Errorf("读取事件记录失败: %v", err)
+		}
+		result = append(result, inc)
+	}
+	return result, nil
+}
+
+func getIncidentByID(id int64) (*Incident, error) {
+	row := incidentDB.QueryRow(
+		`SELECT id, trigger_time, keyword, log_line, script_name, line, clip_path, clip_size, clip_duration, status FROM incidents WHERE id = ?`,
+		id,
+	)
+	var inc Incident
+	if err := row.Scan(&inc.ID, &inc.TriggerTime, &inc.Keyword, &inc.LogLine, &inc.ScriptName, &inc.Line, &inc.ClipPath, &inc.ClipSize, &inc.ClipDuration, &inc.Status); err != nil {
+		return nil, fmt.Errorf("查询事件记录失败: %v", err)
+	}
+	return &inc, nil
+}
+
+// deleteIncidentByID 删除数据库记录，同时清理对应的片段文件和标签 sidecar。
+func deleteIncidentByID(id int64) error {
+	inc, err := getIncidentByID(id)
+	if err != nil {
+		return err
+	}
+	if inc.ClipPath != "" {
+		if err := removeClipFiles(inc.ClipPath); err != nil {
+			log.Printf("删除片段文件失败: %v", err)
+		}
+	}
+	if _, err := incidentDB.Exec(`DELETE FROM incidents WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("删除事件记录失败: %v", err)
+	}
+	return nil
+}
+
+// removeClipFiles 删除片段文件及其 <clip>.tags.json sidecar，供
+// deleteIncidentByID 和 /admin 的删除接口共用，避免两条删除路径各写一遍
+// 而漏掉标签文件。片段文件删除失败会返回 error 交给调用方处理；
+// 标签文件删除失败只记录日志，不影响主流程。
+func removeClipFiles(clipPath string) error {
+	if err := os.Remove(clipPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(tagsPath(filepath.Base(clipPath))); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除标签文件失败: %v", err)
+	}
+	return nil
+}
+
+// deleteIncidentByClipPath 在文件已经被别的入口（比如 /admin 的删除按钮）
+// 直接删掉之后，把对应的事件记录也清掉，避免数据库里留下指向不存在
+// 文件的悬空记录。
+func deleteIncidentByClipPath(clipPath string) error {
+	if _, err := incidentDB.Exec(`DELETE FROM incidents WHERE clip_path = ?`, clipPath); err != nil {
+		return fmt.Errorf("按片段路径删除事件记录失败: %v", err)
+	}
+	return nil
+}
+
+// 旧版文件名约定：<scriptName>_<line>_<yyyyMMddHHmmss>.mp4
+var legacyClipNamePattern = regexp.MustCompile(`^(.+)_(.+)_(\d{14})\.mp4$`)
+
+// migrateExistingErrorVideos 把首次引入数据库之前就已经存在的错误视频
+// 按旧文件名约定解析出脚本/行号/触发时间，补成一条 ready 记录。
+func migrateExistingErrorVideos() error {
+	files, err := filepath.Glob(filepath.Join(errorVideoDir, "*.mp4"))
+	if err != nil {
+		return fmt.Errorf("获取历史错误视频列表失败: %v", err)
+	}
+
+	for _, f := range files {
+		var exists int
+		if err := incidentDB.QueryRow(`SELECT COUNT(1) FROM incidents WHERE clip_path = ?`, f).Scan(&exists); err != nil {
+			return fmt.Errorf("查询历史记录失败: %v", err)
+		}
+		if exists > 0 {
+			continue
+		}
+
+		m := legacyClipNamePattern.FindStringSubmatch(filepath.Base(f))
+		scriptName, line, triggerTime := "unknown", "unknown", time.Time{}
+		if m != nil {
+			scriptName, line = m[1], m[2]
+			if t, err := time.ParseInLocation("20060102150405", m[3], time.Local); err == nil {
+				triggerTime = t
+			}
+		}
+		if triggerTime.IsZero() {
+			if fi, err := os.Stat(f); err == nil {
+				triggerTime = fi.ModTime()
+			} else {
+				triggerTime = time.Now()
+			}
+		}
+
+		size := int64(0)
+		if fi, err := os.Stat(f); err == nil {
+			size = fi.Size()
+		}
+		duration := 0.0
+		if d, err := probeDuration(f); err == nil {
+			duration = d.Seconds()
+		}
+
+		_, err := incidentDB.Exec(
+			`INSERT INTO incidents (trigger_time, keyword, log_line, script_name, line, clip_path, clip_size, clip_duration, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'ready')`,
+			triggerTime, "unknown", "", scriptName, line, f, size, duration,
+		)
+		if err != nil {
+			return fmt.Errorf("迁移 %s 失败: %v", f, err)
+		}
+		log.Printf("已迁移历史视频到事件数据库: %s", f)
+	}
+	return nil
+}
+
+// printDBInfo 实现 -dbinfo：只读打开数据库，打印按关键词/脚本分组的统计信息。
+func printDBInfo() {
+	db, err := sql.Open("sqlite", "file:"+incidentDBPath+"?mode=ro")
+	if err != nil {
+		log.Fatalf("只读打开事件数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	var total int
+	var totalSize int64
+	if err := db.QueryRow(`SELECT COUNT(1), COALESCE(SUM(clip_size), 0) FROM incidents`).Scan(&total, &totalSize); err != nil {
+		log.Fatalf("统计事件总数失败: %v", err)
+	}
+	fmt.Printf("事件总数: %d, 占用磁盘: %.2f MB\n", total, float64(totalSize)/1024/1024)
+
+	fmt.Println("按关键词统计:")
+	printGroupCounts(db, "keyword")
+
+	fmt.Println("按脚本统计:")
+	printGroupCounts(db, "script_name")
+}
+
+func printGroupCounts(db *sql.DB, column string) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT %s, COUNT(1) FROM incidents GROUP BY %s ORDER BY COUNT(1) DESC`, column, column))
+	if err != nil {
+		log.Printf("按 %s 统计失败: %v", column, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			continue
+		}
+		fmt.Printf("  %s: %d\n", strings.TrimSpace(key), count)
+	}
+}
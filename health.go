@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// healthyAfter 是 ffmpeg 进程需要不间断运行多久才被认为是“健康”的，
+// 用来过滤掉刚启动就又崩溃的抖动重启。
+const healthyAfter = 10 * time.Second
+
+// ffmpegSupervisor 包裹一路 ffmpeg 进程的运行状态，
+// startFFmpeg / startLiveStream 各持有自己的一份。
+type ffmpegSupervisor struct {
+	mu          sync.Mutex
+	cmd         *exec.Cmd
+	running     bool
+	healthy     bool
+	restarts    int
+	lastRestart time.Time
+}
+
+var (
+	recordSupervisor = &ffmpegSupervisor{}
+	liveSupervisor   = &ffmpegSupervisor{}
+
+	shuttingDown atomic.Bool
+)
+
+// superviseFFmpeg 以指数退避不断重启 buildCmd 构造出的 ffmpeg 进程，
+// 直到 handleShutdownSignals 触发优雅退出为止。
+func superviseFFmpeg(name string, buildCmd func() *exec.Cmd, sup *ffmpegSupervisor) {
+	backoff := time.Second
+	for !shuttingDown.Load() {
+		cmd := buildCmd()
+
+		sup.mu.Lock()
+		sup.cmd = cmd
+		sup.running = true
+		sup.healthy = false
+		sup.mu.Unlock()
+
+		healthyTimer := time.AfterFunc(healthyAfter, func() {
+			sup.mu.Lock()
+			if sup.running {
+				sup.healthy = true
+			}
+			sup.mu.Unlock()
+		})
+
+		sup.mu.Lock()
+		restarts := sup.restarts
+		sup.mu.Unlock()
+		log.Printf("%s 启动（累计重启 %d 次）", name, restarts)
+		err := cmd.Run()
+		healthyTimer.Stop()
+
+		sup.mu.Lock()
+		sup.running = false
+		sup.healthy = false
+		sup.mu.Unlock()
+
+		if shuttingDown.Load() {
+			return
+		}
+
+		sup.mu.Lock()
+		sup.restarts++
+		sup.lastRestart = time.Now()
+		sup.mu.Unlock()
+
+		log.Printf("%s 退出: %v，%v 后重启", name, err, backoff)
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		} else {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// stopSupervised 对 supervisor 当前管理的进程先礼貌地发信号，
+// 超时还没退出就强制杀掉，避免留下孤儿 ffmpeg 进程。
+func stopSupervised(sup *ffmpegSupervisor, timeout time.Duration) {
+	sup.mu.Lock()
+	cmd := sup.cmd
+	running := sup.running
+	sup.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil || !running {
+		return
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Printf("发送退出信号失败，直接 kill: %v", err)
+		_ = cmd.Process.Kill()
+		return
+	}
+
+	deadline := time.After(timeout)
+	for {
+		sup.mu.Lock()
+		stillRunning := sup.running
+		sup.mu.Unlock()
+		if !stillRunning {
+			return
+		}
+		select {
+		case <-deadline:
+			log.Printf("等待进程退出超时，强制 kill")
+			_ = cmd.Process.Kill()
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// handleShutdownSignals 聚合 SIGINT/SIGTERM，关闭录制和直播的 ffmpeg
+// 进程后再让整个进程退出，不留孤儿 ffmpeg.exe。
+func handleShutdownSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+
+	log.Println("收到退出信号，正在优雅关闭...")
+	shuttingDown.Store(true)
+	stopSupervised(recordSupervisor, 10*time.Second)
+	if config.EnableLive {
+		stopSupervised(liveSupervisor, 10*time.Second)
+	}
+	os.Exit(0)
+}
+
+// ---------------- WebSocket 连接状态 ----------------
+
+var wsState struct {
+	mu         sync.Mutex
+	connected  bool
+	reconnects int
+}
+
+func setWsConnected(connected bool) {
+	wsState.mu.Lock()
+	defer wsState.mu.Unlock()
+	if !connected && wsState.connected {
+		wsState.reconnects++
+	}
+	wsState.connected = connected
+}
+
+func wsStateSnapshot() (bool, int) {
+	wsState.mu.Lock()
+	defer wsState.mu.Unlock()
+	return wsState.connected, wsState.reconnects
+}
+
+// ---------------- /api/health、/api/metrics ----------------
+
+type healthReport struct {
+	FFmpegRunning    bool      `json:"ffmpegRunning"`
+	FFmpegHealthy    bool      `json:"ffmpegHealthy"`
+	FFmpegRestarts   int       `json:"ffmpegRestarts"`
+	LastRestart      time.Time `json:"lastRestart"`
+	WsConnected      bool      `json:"wsConnected"`
+	WsReconnects     int       `json:"wsReconnects"`
+	DiskFreeBytes    uint64    `json:"diskFreeBytes"`
+	CPUPercent       float64   `json:"cpuPercent"`
+	MemRSS           uint64    `json:"memRSS"`
+	OldestSegmentAge float64   `json:"oldestSegmentAge"`
+	SegmentCount     int       `json:"segmentCount"`
+}
+
+func sampleHealth() healthReport {
+	recordSupervisor.mu.Lock()
+	running := recordSupervisor.running
+	healthy := recordSupervisor.healthy
+	restarts := recordSupervisor.restarts
+	lastRestart := recordSupervisor.lastRestart
+	recordSupervisor.mu.Unlock()
+
+	wsConnected, wsReconnects := wsStateSnapshot()
+
+	var diskFree uint64
+	if usage, err := disk.Usage(videoDir); err == nil {
+		diskFree = usage.Free
+	}
+
+	var cpuPercent float64
+	if percents, err := cpu.Percent(200*time.Millisecond, false); err == nil && len(percents) > 0 {
+		cpuPercent = percents[0]
+	}
+
+	var memRSS uint64
+	if proc, err := process.NewProcess(int32(os.Getpid())); err == nil {
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			memRSS = memInfo.RSS
+		}
+	}
+
+	segments, _ := scanSegments()
+	oldestAge := 0.0
+	if len(segments) > 0 {
+		oldestAge = time.Since(segments[0].StartTime).Seconds()
+	}
+
+	return healthReport{
+		FFmpegRunning:    running,
+		FFmpegHealthy:    healthy,
+		FFmpegRestarts:   restarts,
+		LastRestart:      lastRestart,
+		WsConnected:      wsConnected,
+		WsReconnects:     wsReconnects,
+		DiskFreeBytes:    diskFree,
+		CPUPercent:       cpuPercent,
+		MemRSS:           memRSS,
+		OldestSegmentAge: oldestAge,
+		SegmentCount:     len(segments),
+	}
+}
+
+func handleHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, sampleHealth())
+}
+
+func handleMetrics(c *gin.Context) {
+	h := sampleHealth()
+	var b strings.Builder
+
+	writeGauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+
+	writeGauge("bgi_ffmpeg_running", "ffmpeg 录制进程是否在运行 (1/0)", boolToFloat(h.FFmpegRunning))
+	writeGauge("bgi_ffmpeg_healthy", "ffmpeg 录制进程是否已稳定运行超过 healthyAfter (1/0)", boolToFloat(h.FFmpegHealthy))
+	writeGauge("bgi_ffmpeg_restarts_total", "ffmpeg 累计重启次数", float64(h.FFmpegRestarts))
+	writeGauge("bgi_ws_connected", "WebSocket 日志源是否已连接 (1/0)", boolToFloat(h.WsConnected))
+	writeGauge("bgi_ws_reconnects_total", "WebSocket 累计重连次数", float64(h.WsReconnects))
+	writeGauge("bgi_disk_free_bytes", "录制目录所在磁盘的剩余字节数", float64(h.DiskFreeBytes))
+	writeGauge("bgi_cpu_percent", "采样得到的整机 CPU 使用率", h.CPUPercent)
+	writeGauge("bgi_mem_rss_bytes", "本进程常驻内存大小", float64(h.MemRSS))
+	writeGauge("bgi_oldest_segment_age_seconds", "最老保留分段的存活时长", h.OldestSegmentAge)
+	writeGauge("bgi_segment_count", "当前保留的分段数量", float64(h.SegmentCount))
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	liveDir          = "./live"
+	livePlaylistName = "stream.m3u8"
+	maxLiveSegments  = 30 // 超过这个数量的 .ts 分片会被清理
+)
+
+func buildLiveCmd() *exec.Cmd {
+	playlist := filepath.Join(liveDir, livePlaylistName)
+	segmentPattern := filepath.Join(liveDir, "live_%05d.ts")
+
+	cmd := exec.Command(ffmpegExePath,
+		"-y",
+		"-f", "gdigrab",
+		"-framerate", "30",
+		"-video_size", "1920x1080",
+		"-i", "desktop",
+		"-f", "lavfi", "-i", "anullsrc",
+		"-vcodec", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-b:v", "2000k",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", config.HlsSegmentDuration),
+		"-hls_list_size", fmt.Sprintf("%d", config.HlsListSize),
+		"-hls_flags", "delete_segments+append_list",
+		"-hls_segment_filename", segmentPattern,
+		playlist,
+	)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// startLiveStream 额外起一路 ffmpeg，把桌面画面同时编码成 HLS，
+// 与 startFFmpeg 的 MP4 分段录制并行跑，互不影响，同样交给
+// supervisor 管理，崩溃后自动重启。
+func startLiveStream() {
+	log.Println("开始推送直播流 (HLS)...")
+	superviseFFmpeg("直播推流进程", buildLiveCmd, liveSupervisor)
+}
+
+// cleanupOldLiveSegments 兜底清理遗留的 .ts 分片，ffmpeg 的
+// delete_segments 已经会删大部分，这里按 cleanupOldVideos 的思路再扫一遍。
+func cleanupOldLiveSegments() {
+	files, err := filepath.Glob(filepath.Join(liveDir, "live_*.ts"))
+	if err != nil {
+		log.Printf("获取直播分片列表失败: %v", err)
+		return
+	}
+	if len(files) <= maxLiveSegments {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		fi, _ := os.Stat(files[i])
+		fj, _ := os.Stat(files[j])
+		return fi.ModTime().Before(fj.ModTime())
+	})
+	for _, f := range files[:len(files)-maxLiveSegments] {
+		log.Printf("🗑 删除旧直播分片: %s", f)
+		os.Remove(f)
+	}
+}
+
+// serveLiveFile 手动提供 m3u8/ts 文件，带上播放器需要的 Content-Type。
+func serveLiveFile(c *gin.Context) {
+	name := filepath.Base(c.Param("filepath"))
+	full := filepath.Join(liveDir, name)
+
+	if _, err := os.Stat(full); os.IsNotExist(err) {
+		c.String(http.StatusNotFound, "未找到直播文件")
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".m3u8"):
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	case strings.HasSuffix(name, ".ts"):
+		c.Header("Content-Type", "video/mp2t")
+	}
+	c.File(full)
+}
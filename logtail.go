@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logLineDedupeWindow = 2 * time.Second
+
+var (
+	recentLines   = make(map[string]time.Time)
+	recentLinesMu sync.Mutex
+)
+
+// isDuplicateLine 在 WebSocket 和文件 tail 两路触发源同时开启时，
+// 防止同一行日志在短时间内被当成两次独立的触发来处理。
+func isDuplicateLine(msg string) bool {
+	recentLinesMu.Lock()
+	defer recentLinesMu.Unlock()
+
+	now := time.Now()
+	for line, seenAt := range recentLines {
+		if now.Sub(seenAt) > logLineDedupeWindow {
+			delete(recentLines, line)
+		}
+	}
+
+	if seenAt, ok := recentLines[msg]; ok && now.Sub(seenAt) < logLineDedupeWindow {
+		return true
+	}
+	recentLines[msg] = now
+	return false
+}
+
+// tailLogFile 是没有 /ws/<file> 接口时的兜底方案：轮询文件大小变化，
+// 读取新增内容并按行喂给 handleLogMessage。
+//
+// 轮转检测用两个独立信号：体积变小（copytruncate 类工具）和文件
+// 身份变化（rename 类工具，os.SameFile 底层比较的是设备+inode/文件
+// 索引，不是路径），任意一个触发都会重新从 0 开始读。
+func tailLogFile(path string) {
+	var offset int64
+	var lastInfo os.FileInfo
+	if fi, err := os.Stat(path); err == nil {
+		offset = fi.Size() // 从文件末尾开始，只处理之后新增的内容
+		lastInfo = fi
+	}
+
+	backoff := time.Second
+	for {
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Printf("读取日志文件状态失败，%v 后重试: %v", backoff, err)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		rotated := fi.Size() < offset
+		if !rotated && lastInfo != nil && !os.SameFile(lastInfo, fi) {
+			rotated = true
+		}
+		if rotated {
+			log.Printf("检测到日志文件被轮转（体积变小或文件身份变化），从头开始读取: %s", path)
+			offset = 0
+		}
+		lastInfo = fi
+
+		if fi.Size() > offset {
+			newOffset, err := readAppendedLines(path, offset)
+			if err != nil {
+				log.Printf("读取日志增量失败: %v", err)
+			} else {
+				offset = newOffset
+			}
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// readAppendedLines 从 offset 开始读取文件剩余内容，按行拆分后逐条
+// 交给 handleLogMessage，返回读取后的新偏移量。
+func readAppendedLines(path string, offset int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return offset, err
+	}
+
+	// 只处理到最后一个换行符为止的完整行，把换行符之后的半行留在文件里，
+	// 偏移量也只推进到这里，避免把还没写完的一行当成完整日志处理掉、
+	// 下一轮又读不到它的后半截。
+	lastNewline := strings.LastIndexByte(string(data), '\n')
+	if lastNewline < 0 {
+		return offset, nil
+	}
+
+	for _, line := range strings.Split(string(data[:lastNewline]), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		handleLogMessage(line)
+	}
+
+	return offset + int64(lastNewline) + 1, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -16,6 +17,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,9 +28,31 @@ import (
 var embeddedFiles embed.FS
 
 type Config struct {
-	ServerAddr string   `yaml:"serverAddr"`
-	FileName   string   `yaml:"fileName"`
-	Keywords   []string `yaml:"keywords"`
+	ServerAddr          string       `yaml:"serverAddr"`
+	FileName            string       `yaml:"fileName"`
+	Keywords            []string     `yaml:"keywords"`
+	EnableLive          bool         `yaml:"enableLive"`
+	HlsSegmentDuration  int          `yaml:"hlsSegmentDuration"`
+	HlsListSize         int          `yaml:"hlsListSize"`
+	ClipPreRollSeconds  int          `yaml:"clipPreRollSeconds"`
+	ClipPostRollSeconds int          `yaml:"clipPostRollSeconds"`
+	Admin               AdminConfig  `yaml:"admin"`
+	LogFile             string       `yaml:"logFile"`
+	Upload              UploadConfig `yaml:"upload"`
+}
+
+// UploadConfig 描述把 error_videos 里的片段同步到远端的 rclone 配置。
+type UploadConfig struct {
+	Remote           string `yaml:"remote"` // rclone remote:path，例如 s3:incidents/bgi/
+	DeleteLocalAfter bool   `yaml:"deleteLocalAfter"`
+	Retry            int    `yaml:"retry"`
+}
+
+// AdminConfig 是 /admin 后台的登录凭据，密码以 bcrypt 哈希的形式
+// 保存在配置文件里，绝不在内存外出现明文。
+type AdminConfig struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"passwordHash"`
 }
 
 var config Config
@@ -41,54 +65,120 @@ func init() {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		log.Fatalf("解析配置文件失败: %v", err)
 	}
+	if config.HlsSegmentDuration <= 0 {
+		config.HlsSegmentDuration = 4
+	}
+	if config.HlsListSize <= 0 {
+		config.HlsListSize = 6
+	}
+	if config.ClipPreRollSeconds <= 0 {
+		config.ClipPreRollSeconds = 60
+	}
+	if config.ClipPostRollSeconds <= 0 {
+		config.ClipPostRollSeconds = 60
+	}
+	if config.Upload.Retry <= 0 {
+		config.Upload.Retry = 3
+	}
 	log.Printf("配置文件内容: %+v", config)
 }
 
 const (
-	videoDir      = "./videos"
-	errorVideoDir = "./error_videos"
-	maxVideos     = 5
-	segmentTime   = 180 // 每段3分钟
-	ffmpegExePath = "./ffmpeg.exe"
+	videoDir       = "./videos"
+	errorVideoDir  = "./error_videos"
+	maxVideos      = 30
+	segmentTime    = 180 // 每段3分钟
+	ffmpegExePath  = "./ffmpeg.exe"
+	ffprobeExePath = "./ffprobe.exe"
+
+	segmentFilePrefix = "record_"
+	segmentFileExt    = ".ts"
+	segmentStrftime   = "%Y%m%d_%H%M%S"
+	segmentTimeLayout = "20060102_150405" // 需与 segmentStrftime 保持一致
 )
 
 var (
-	lastTrigger time.Time
-	copyLock    sync.Mutex
+	copyLock sync.Mutex
+
+	keywordCooldowns  = make(map[string]time.Time)
+	keywordCooldownMu sync.Mutex
 )
 
 func main() {
+	flag.Parse()
+	if *dbInfoFlag {
+		printDBInfo()
+		return
+	}
+
 	// 创建视频目录
 	if err := os.MkdirAll(videoDir, os.ModePerm); err != nil {
 		log.Fatalf("创建视频目录失败: %v", err)
 	}
 
+	// 初始化事件数据库（含历史错误视频的迁移）
+	if err := initIncidentDB(); err != nil {
+		log.Fatalf("初始化事件数据库失败: %v", err)
+	}
+
 	// 启动清理线程
 	go func() {
 		for {
 			time.Sleep(60 * time.Second)
 			cleanupOldVideos()
+			if config.EnableLive {
+				cleanupOldLiveSegments()
+			}
 		}
 	}()
 
-	// 启动 WebSocket 日志监听
-	go func() {
-		u := url.URL{Scheme: "ws", Host: config.ServerAddr, Path: "/ws/" + config.FileName}
-		wsBgiLog(u.String())
-	}()
+	// 启动直播推流（HLS）
+	if config.EnableLive {
+		if err := os.MkdirAll(liveDir, os.ModePerm); err != nil {
+			log.Fatalf("创建直播目录失败: %v", err)
+		}
+		go startLiveStream()
+	}
+
+	// 启动 WebSocket 日志监听（并非所有部署都暴露这个接口，因此可选）
+	if config.ServerAddr != "" {
+		go func() {
+			u := url.URL{Scheme: "ws", Host: config.ServerAddr, Path: "/ws/" + config.FileName}
+			wsBgiLog(u.String())
+		}()
+	}
+
+	// 日志文件 tail 兜底：两路触发源可以同时启用，靠 handleLogMessage
+	// 里的去重逻辑避免同一行日志被重复处理。
+	if config.LogFile != "" {
+		go tailLogFile(config.LogFile)
+	}
+
+	// 启动异地上传队列（落盘持久化，崩溃重启后继续上传）
+	if config.Upload.Remote != "" {
+		if err := loadUploadQueue(); err != nil {
+			log.Printf("加载上传队列失败: %v", err)
+		}
+		go runUploadWorker()
+	}
 
 	// 启动 Web 界面
 	go func() {
 		startWebServer()
 	}()
 
-	// 启动录制
+	// 监听 Ctrl+C / 结束信号，优雅地关闭 ffmpeg 再退出，避免留下孤儿进程
+	go handleShutdownSignals()
+
+	// 启动录制（supervisor 会在 ffmpeg 异常退出时自动重启）
 	startFFmpeg()
 }
 
-func startFFmpeg() {
-	outputPattern := filepath.Join(videoDir, "record_%03d.mp4")
-	log.Println("开始录制，每3分钟自动分段，并只保留最新5段...")
+func buildRecordCmd() *exec.Cmd {
+	// strftime 命名的 .ts 分段：既能被 segment muxer 无损续写，
+	// 又能在不读取文件内容的情况下从文件名还原每段的起始时间，
+	// 供 extractClip 的环形缓冲区按时间窗口挑选分段。
+	outputPattern := filepath.Join(videoDir, segmentFilePrefix+segmentStrftime+segmentFileExt)
 
 	cmd := exec.Command(ffmpegExePath,
 		"-y",
@@ -108,17 +198,21 @@ func startFFmpeg() {
 		"-c:a", "aac",
 		"-f", "segment",
 		"-segment_time", fmt.Sprintf("%d", segmentTime),
+		"-segment_format", "mpegts",
 		"-reset_timestamps", "1",
+		"-strftime", "1",
 		outputPattern,
 	)
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	return cmd
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("录制失败: %v", err)
-	}
+func startFFmpeg() {
+	log.Println("开始录制，每3分钟自动分段（.ts），并只保留最新分段...")
+	superviseFFmpeg("录制进程", buildRecordCmd, recordSupervisor)
 }
 
 // ---------------- WebSocket 日志监听 ----------------
@@ -136,6 +230,8 @@ func connectAndListen(wsURL string) error {
 		return fmt.Errorf("连接失败: %v", err)
 	}
 	defer conn.Close()
+	defer setWsConnected(false)
+	setWsConnected(true)
 	log.Println("✅ WebSocket 已连接，开始接收日志...")
 
 	for {
@@ -147,22 +243,46 @@ func connectAndListen(wsURL string) error {
 	}
 }
 
+// tryStartCooldown 让每个关键词独立计算冷却期，这样一个高频关键词
+// 不会连带压制住其他不相关关键词的触发。
+func tryStartCooldown(keyword string) bool {
+	keywordCooldownMu.Lock()
+	defer keywordCooldownMu.Unlock()
+	if last, ok := keywordCooldowns[keyword]; ok && time.Since(last) < 3*time.Minute {
+		return false
+	}
+	keywordCooldowns[keyword] = time.Now()
+	return true
+}
+
 func handleLogMessage(msg string) {
+	if isDuplicateLine(msg) {
+		return
+	}
 	log.Printf("[日志] %s", msg)
 	for _, keyword := range config.Keywords {
 		if strings.Contains(msg, keyword) {
-			if time.Since(lastTrigger) < 3*time.Minute {
+			if !tryStartCooldown(keyword) {
 				log.Printf("检测到关键词 [%s]，但仍在冷却期。", keyword)
-				return
+				continue
+			}
+			triggerTime := time.Now()
+			postRoll := time.Duration(config.ClipPostRollSeconds) * time.Second
+			log.Printf("检测到关键词 [%s]，等待 %v 后截取触发点前后的片段...", keyword, postRoll)
+
+			incidentID, err := insertIncident(triggerTime, keyword, msg)
+			if err != nil {
+				log.Printf("写入事件记录失败: %v", err)
 			}
-			lastTrigger = time.Now()
-			log.Printf("检测到关键词 [%s]，2分钟后复制最近2个视频...", keyword)
 			go func() {
-				time.Sleep(2 * time.Minute)
-				if err := copyLatestVideos(2); err != nil {
-					log.Printf("复制视频出错: %v", err)
+				time.Sleep(postRoll)
+				if err := extractClip(triggerTime, keyword, incidentID); err != nil {
+					log.Printf("截取片段出错: %v", err)
+					if incidentID != 0 {
+						_ = markIncidentFailed(incidentID)
+					}
 				} else {
-					log.Printf("✅ 已复制最新2个视频到 error_videos 文件夹。")
+					log.Printf("✅ 已生成触发片段到 error_videos 文件夹。")
 				}
 			}()
 		}
@@ -171,7 +291,7 @@ func handleLogMessage(msg string) {
 
 // ---------------- 视频管理 ----------------
 func cleanupOldVideos() {
-	files, err := filepath.Glob(filepath.Join(videoDir, "record_*.mp4"))
+	files, err := filepath.Glob(filepath.Join(videoDir, segmentFilePrefix+"*"+segmentFileExt))
 	if err != nil {
 		log.Printf("获取视频列表失败: %v", err)
 		return
@@ -230,68 +350,6 @@ func fetchIndexData() (map[string]interface{}, error) {
 	return m, nil
 }
 
-func copyLatestVideos(n int) error {
-	copyLock.Lock()
-	defer copyLock.Unlock()
-
-	if err := os.MkdirAll(errorVideoDir, os.ModePerm); err != nil {
-		return fmt.Errorf("创建错误视频目录失败: %v", err)
-	}
-
-	indexData, err := fetchIndexData()
-	if err != nil {
-		log.Printf("无法获取索引信息: %v", err)
-		indexData = map[string]interface{}{
-			"scriptName": "unknown",
-			"line":       "unknown",
-		}
-	}
-
-	files, err := filepath.Glob(filepath.Join(videoDir, "record_*.mp4"))
-	if err != nil {
-		return fmt.Errorf("获取视频列表失败: %v", err)
-	}
-	if len(files) == 0 {
-		return fmt.Errorf("没有找到可复制的视频")
-	}
-
-	sort.Slice(files, func(i, j int) bool {
-		fi, _ := os.Stat(files[i])
-		fj, _ := os.Stat(files[j])
-		return fi.ModTime().After(fj.ModTime())
-	})
-
-	if len(files) > n {
-		files = files[:n]
-	}
-
-	for _, f := range files {
-		log.Printf("检测文件是否稳定: %s", f)
-		if !waitForFileStable(f, 30*time.Second) {
-			log.Printf("文件未稳定，跳过: %s", f)
-			continue
-		}
-		errFileName := fmt.Sprintf("%s_%s_%s.mp4",
-			fmt.Sprint(indexData["scriptName"]),
-			filepath.Base(fmt.Sprint(indexData["line"])),
-			time.Now().Format("20060102150405"),
-		)
-		dest := filepath.Join(errorVideoDir, errFileName)
-
-		data, err := os.ReadFile(f)
-		if err != nil {
-			log.Printf("读取视频失败: %v", err)
-			continue
-		}
-		if err := os.WriteFile(dest, data, 0644); err != nil {
-			log.Printf("写入视频失败: %v", err)
-			continue
-		}
-		log.Printf("✅ 复制完成: %s → %s", f, dest)
-	}
-	return nil
-}
-
 // ---------------- Gin Web 部分 ----------------
 func startWebServer() {
 	gin.SetMode(gin.ReleaseMode)
@@ -309,9 +367,24 @@ func startWebServer() {
 		c.DataFromReader(http.StatusOK, -1, "text/html; charset=utf-8", data, nil)
 	})
 
-	router.Static("/error_videos", errorVideoDir)
+	// error_videos 里是桌面录制的原始画面，和 /admin 下的操作一样敏感，
+	// 必须挂同一套 Basic Auth 中间件，不能再裸奔。
+	errorVideos := router.Group("/error_videos", adminAuthMiddleware())
+	errorVideos.Static("", errorVideoDir)
+
+	if config.EnableLive {
+		router.GET("/live", func(c *gin.Context) {
+			data, err := subFS.Open("live.html")
+			if err != nil {
+				c.String(http.StatusNotFound, "live.html not found")
+				return
+			}
+			c.DataFromReader(http.StatusOK, -1, "text/html; charset=utf-8", data, nil)
+		})
+		router.GET("/live/*filepath", serveLiveFile)
+	}
 
-	router.GET("/api/errors", func(c *gin.Context) {
+	router.GET("/api/errors", adminAuthMiddleware(), func(c *gin.Context) {
 		files, err := listErrorVideos()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -320,7 +393,7 @@ func startWebServer() {
 		c.JSON(http.StatusOK, gin.H{"count": len(files), "data": files})
 	})
 
-	router.GET("/api/error/:name", func(c *gin.Context) {
+	router.GET("/api/error/:name", adminAuthMiddleware(), func(c *gin.Context) {
 		name := filepath.Base(c.Param("name"))
 		full := filepath.Join(errorVideoDir, name)
 		if _, err := os.Stat(full); os.IsNotExist(err) {
@@ -330,6 +403,64 @@ func startWebServer() {
 		c.File(full)
 	})
 
+	router.GET("/api/incidents", adminAuthMiddleware(), func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit <= 0 {
+			limit = 50
+		}
+		incidents, err := listIncidents(incidentFilter{
+			Keyword: c.Query("keyword"),
+			From:    c.Query("from"),
+			To:      c.Query("to"),
+			Script:  c.Query("script"),
+			Limit:   limit,
+			Offset:  offset,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"count": len(incidents), "data": incidents})
+	})
+
+	router.GET("/api/incident/:id", adminAuthMiddleware(), func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "非法的事件 ID"})
+			return
+		}
+		incident, err := getIncidentByID(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, incident)
+	})
+
+	router.GET("/api/upload/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, uploadQueueStatus())
+	})
+
+	router.GET("/api/health", handleHealth)
+	router.GET("/api/metrics", handleMetrics)
+
+	router.DELETE("/api/incident/:id", adminAuthMiddleware(), func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "非法的事件 ID"})
+			return
+		}
+		if err := deleteIncidentByID(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": id})
+	})
+
+	admin := router.Group("/admin", adminAuthMiddleware())
+	registerAdminRoutes(admin, subFS)
+
 	log.Println("🌐 服务启动: http://localhost:10189")
 	router.Run(":10189")
 }
@@ -347,12 +478,21 @@ func listErrorVideos() ([]gin.H, error) {
 	var res []gin.H
 	for _, f := range files {
 		info, _ := os.Stat(f)
-		res = append(res, gin.H{
+		entry := gin.H{
 			"name": info.Name(),
 			"size": info.Size(),
 			"time": info.ModTime().Format("2006-01-02 15:04:05"),
 			"url":  "/error_videos/" + info.Name(),
-		})
+		}
+		if task, ok := findUploadTask(f); ok {
+			entry["uploadStatus"] = task.Status
+			entry["remoteUrl"] = task.RemoteURL
+		}
+		if tags, ok := readClipTags(info.Name()); ok {
+			entry["tags"] = tags.Tags
+			entry["note"] = tags.Note
+		}
+		res = append(res, entry)
 	}
 	return res, nil
 }
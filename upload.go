@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	rcloneExePath   = "./rclone.exe"
+	uploadQueuePath = "./upload_queue.json"
+)
+
+// uploadTask 是上传队列里的一条记录，落盘成 JSON 以便崩溃重启后续传。
+type uploadTask struct {
+	ClipPath    string    `json:"clipPath"`
+	Remote      string    `json:"remote"`
+	Status      string    `json:"status"` // pending / uploaded / failed
+	RemoteURL   string    `json:"remoteUrl"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError"`
+	NextRetryAt time.Time `json:"nextRetryAt"`
+}
+
+var (
+	uploadQueue   []*uploadTask
+	uploadQueueMu sync.Mutex
+)
+
+// enqueueUpload 在片段生成后把它加入上传队列，真正的上传由
+// runUploadWorker 异步处理，不阻塞截取片段的主流程。
+func enqueueUpload(clipPath string) {
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+
+	uploadQueue = append(uploadQueue, &uploadTask{
+		ClipPath: clipPath,
+		Remote:   config.Upload.Remote,
+		Status:   "pending",
+	})
+	persistUploadQueueLocked()
+}
+
+// runUploadWorker 每隔几秒扫一遍队列，把 pending（或还没用完重试次数的
+// failed）任务依次上传，成功后按配置删除本地文件。
+func runUploadWorker() {
+	for {
+		task := nextPendingUpload()
+		if task == nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		uploadOne(task)
+	}
+}
+
+func nextPendingUpload() *uploadTask {
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+	for _, t := range uploadQueue {
+		if t.Status == "pending" {
+			return t
+		}
+		if t.Status == "failed" && t.Attempts < config.Upload.Retry && !time.Now().Before(t.NextRetryAt) {
+			return t
+		}
+	}
+	return nil
+}
+
+func uploadOne(task *uploadTask) {
+	cmd := exec.Command(rcloneExePath, "copy", task.ClipPath, task.Remote)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+
+	task.Attempts++
+	if err != nil {
+		task.Status = "failed"
+		task.LastError = fmt.Sprintf("rclone 上传失败: %v", err)
+		// 指数退避，避免远端/网络一直不通时原地狂刷重试。
+		backoff := time.Duration(1<<uint(task.Attempts)) * time.Second
+		if backoff > 5*time.Minute {
+			backoff = 5 * time.Minute
+		}
+		task.NextRetryAt = time.Now().Add(backoff)
+		log.Printf("❌ 上传 %s 到 %s 失败（第 %d 次，%v 后重试）: %v", task.ClipPath, task.Remote, task.Attempts, backoff, err)
+	} else {
+		task.Status = "uploaded"
+		task.RemoteURL = task.Remote + "/" + filepath.Base(task.ClipPath)
+		task.LastError = ""
+		log.Printf("✅ 已上传 %s 到 %s", task.ClipPath, task.Remote)
+		if config.Upload.DeleteLocalAfter {
+			if err := os.Remove(task.ClipPath); err != nil {
+				log.Printf("上传后删除本地文件失败: %v", err)
+			}
+		}
+	}
+	persistUploadQueueLocked()
+}
+
+func findUploadTask(clipPath string) (*uploadTask, bool) {
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+	for _, t := range uploadQueue {
+		if t.ClipPath == clipPath {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// uploadQueueStatus 供 /api/upload/status 使用，汇报队列深度和最近一次错误。
+func uploadQueueStatus() map[string]interface{} {
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+
+	pending := 0
+	lastError := ""
+	for _, t := range uploadQueue {
+		if t.Status == "pending" || t.Status == "failed" {
+			pending++
+		}
+		if t.LastError != "" {
+			lastError = t.LastError
+		}
+	}
+	return map[string]interface{}{
+		"queueDepth": pending,
+		"total":      len(uploadQueue),
+		"lastError":  lastError,
+	}
+}
+
+func persistUploadQueueLocked() {
+	data, err := json.MarshalIndent(uploadQueue, "", "  ")
+	if err != nil {
+		log.Printf("序列化上传队列失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(uploadQueuePath, data, 0644); err != nil {
+		log.Printf("保存上传队列失败: %v", err)
+	}
+}
+
+// loadUploadQueue 在启动时把上次落盘的队列读回内存，确保进程崩溃
+// 不会悄悄丢失还没上传完的片段。
+func loadUploadQueue() error {
+	data, err := os.ReadFile(uploadQueuePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取上传队列失败: %v", err)
+	}
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+	return json.Unmarshal(data, &uploadQueue)
+}